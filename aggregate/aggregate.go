@@ -0,0 +1,117 @@
+// Package aggregate buckets points by geohash cell over consecutive fixed
+// time windows, so a busy server publishes one {lat, lng, count} summary
+// per cell per flush instead of flooding every subscriber with one message
+// per log line. Windows do not overlap: each flush counts only the points
+// added since the previous flush.
+package aggregate
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mmcloughlin/geohash"
+)
+
+const (
+	defaultPrecision = 4
+	defaultWindow    = time.Second
+)
+
+// Point is an aggregated hotspot: the center of a geohash cell and how
+// many points landed in it during the flush window.
+type Point struct {
+	Lat   float64 `json:"lat"`
+	Lng   float64 `json:"lng"`
+	Count int     `json:"count"`
+}
+
+// Config controls the geohash precision and flush cadence.
+type Config struct {
+	// Precision is the geohash length cells are bucketed by. A longer
+	// hash means smaller, more precise cells. Defaults to 4.
+	Precision uint
+
+	// Window is how often buckets are flushed and reset. Defaults to
+	// one second.
+	Window time.Duration
+}
+
+// Aggregator buckets incoming points by geohash cell and periodically
+// flushes the per-cell counts to sink.
+type Aggregator struct {
+	precision uint
+	window    time.Duration
+	sink      func(Point)
+
+	mu      sync.Mutex
+	buckets map[string]int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New starts an Aggregator per cfg, flushing aggregated points to sink
+// until Stop is called.
+func New(cfg Config, sink func(Point)) *Aggregator {
+	a := &Aggregator{
+		precision: cfg.Precision,
+		window:    cfg.Window,
+		sink:      sink,
+		buckets:   make(map[string]int),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if a.precision == 0 {
+		a.precision = defaultPrecision
+	}
+	if a.window <= 0 {
+		a.window = defaultWindow
+	}
+
+	go a.run()
+	return a
+}
+
+// Add buckets a single point by its geohash cell.
+func (a *Aggregator) Add(lat, lng float64) {
+	hash := geohash.EncodeWithPrecision(lat, lng, a.precision)
+
+	a.mu.Lock()
+	a.buckets[hash]++
+	a.mu.Unlock()
+}
+
+func (a *Aggregator) run() {
+	defer close(a.done)
+
+	t := time.NewTicker(a.window)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			a.flush()
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+func (a *Aggregator) flush() {
+	a.mu.Lock()
+	buckets := a.buckets
+	a.buckets = make(map[string]int)
+	a.mu.Unlock()
+
+	for hash, count := range buckets {
+		lat, lng := geohash.DecodeCenter(hash)
+		a.sink(Point{Lat: lat, Lng: lng, Count: count})
+	}
+}
+
+// Stop stops the flush loop. Any points bucketed since the last flush are
+// discarded.
+func (a *Aggregator) Stop() {
+	close(a.stop)
+	<-a.done
+}
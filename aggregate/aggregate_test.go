@@ -0,0 +1,56 @@
+package aggregate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mmcloughlin/geohash"
+)
+
+func TestAggregatorFlushesBucketedCounts(t *testing.T) {
+	flushed := make(chan Point, 1)
+	a := New(Config{Precision: 4, Window: 10 * time.Millisecond}, func(p Point) {
+		flushed <- p
+	})
+	defer a.Stop()
+
+	a.Add(37.7749, -122.4194)
+	a.Add(37.7750, -122.4195) // same geohash-4 cell
+
+	select {
+	case p := <-flushed:
+		if p.Count != 2 {
+			t.Fatalf("got count %d, want 2", p.Count)
+		}
+		wantLat, wantLng := geohash.DecodeCenter(geohash.EncodeWithPrecision(37.7749, -122.4194, 4))
+		if p.Lat != wantLat || p.Lng != wantLng {
+			t.Fatalf("got (%v, %v), want the cell center (%v, %v)", p.Lat, p.Lng, wantLat, wantLng)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a flush")
+	}
+}
+
+func TestAggregatorSeparatesDistantCells(t *testing.T) {
+	flushed := make(chan Point, 2)
+	a := New(Config{Precision: 4, Window: 10 * time.Millisecond}, func(p Point) {
+		flushed <- p
+	})
+	defer a.Stop()
+
+	a.Add(37.7749, -122.4194)
+	a.Add(51.5074, -0.1278)
+
+	seen := 0
+	for seen < 2 {
+		select {
+		case p := <-flushed:
+			if p.Count != 1 {
+				t.Fatalf("got count %d, want 1", p.Count)
+			}
+			seen++
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for both cells to flush")
+		}
+	}
+}
@@ -0,0 +1,247 @@
+// Package broadcaster fans published values out to subscribed websocket
+// clients, keeping a bounded replay buffer so a newly opened client isn't
+// left staring at a blank map while it waits for the next live point.
+package broadcaster
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/scramble-suit/geoip-live-map/metrics"
+)
+
+const (
+	defaultReplaySize      = 1000
+	defaultPersistMaxBytes = 10 * 1024 * 1024
+)
+
+// Config controls the size of the in-memory replay buffer and whether (and
+// how) it's persisted to disk.
+type Config struct {
+	// ReplaySize is how many recent points are kept for backfilling new
+	// subscribers. Defaults to 1000.
+	ReplaySize int
+
+	// PersistPath, if set, is an append-only JSONL file the replay
+	// buffer is mirrored to, so a restart doesn't lose recent context.
+	PersistPath string
+
+	// PersistMaxBytes rotates PersistPath once it grows past this size.
+	// Defaults to 10MiB.
+	PersistMaxBytes int64
+}
+
+// entry is a single replayable point, stamped with the server's publish
+// time. A Point already aggregates every line seen in its flush window
+// into one count, so there is no single "originating" log timestamp to
+// attach; Time and ?since on /ws deliberately mean publish (receive)
+// time, not source log time.
+type entry struct {
+	V    interface{} `json:"v"`
+	Time time.Time   `json:"time"`
+}
+
+// Broadcaster fans out published values to subscribers and retains the
+// most recent ones for replay.
+type Broadcaster struct {
+	mu sync.Mutex
+	cs []chan<- interface{}
+
+	replay     []entry
+	replaySize int
+
+	// persistMu guards the fields below and is held only for the disk
+	// write itself, never across mu, so a slow write never delays a
+	// broadcast to subscribers.
+	persistMu       sync.Mutex
+	persistPath     string
+	persistMaxBytes int64
+	persistFile     *os.File
+	persistSize     int64
+}
+
+// New creates a Broadcaster per cfg. If cfg.PersistPath is set, any
+// existing replay log is loaded so the buffer survives a restart.
+func New(cfg Config) *Broadcaster {
+	b := &Broadcaster{
+		replaySize:      cfg.ReplaySize,
+		persistPath:     cfg.PersistPath,
+		persistMaxBytes: cfg.PersistMaxBytes,
+	}
+	if b.replaySize <= 0 {
+		b.replaySize = defaultReplaySize
+	}
+	if b.persistMaxBytes <= 0 {
+		b.persistMaxBytes = defaultPersistMaxBytes
+	}
+
+	if b.persistPath != "" {
+		b.replay = loadReplay(b.persistPath, b.replaySize)
+
+		f, err := os.OpenFile(b.persistPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Printf("broadcaster: failed to open replay log %s: %v", b.persistPath, err)
+		} else {
+			b.persistFile = f
+			if info, err := f.Stat(); err == nil {
+				b.persistSize = info.Size()
+			}
+		}
+	}
+
+	return b
+}
+
+// loadReplay reads up to n trailing entries from an existing replay log.
+func loadReplay(path string, n int) []entry {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var entries []entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			log.Printf("broadcaster: skipping malformed replay entry: %v", err)
+			continue
+		}
+		entries = append(entries, e)
+		if len(entries) > n {
+			entries = entries[len(entries)-n:]
+		}
+	}
+	return entries
+}
+
+// Sub registers c to receive live points and returns the buffered replay
+// points published at or after since (the zero time replays the whole
+// buffer).
+func (b *Broadcaster) Sub(c chan<- interface{}, since time.Time) []interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.cs = append(b.cs, c)
+	metrics.WSSubscribers.Inc()
+
+	var backlog []interface{}
+	for _, e := range b.replay {
+		if !since.IsZero() && e.Time.Before(since) {
+			continue
+		}
+		backlog = append(backlog, e.V)
+	}
+	return backlog
+}
+
+// Usub unregisters c.
+func (b *Broadcaster) Usub(c chan<- interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := b.cs[:0]
+	for _, x := range b.cs {
+		if x != c {
+			n = append(n, x)
+		}
+	}
+	b.cs = n
+	metrics.WSSubscribers.Dec()
+}
+
+// Pub records v in the replay buffer and broadcasts it to every current
+// subscriber. Persisting v to disk, if configured, happens after the
+// broadcast so a slow write never holds up fan-out to subscribers.
+func (b *Broadcaster) Pub(v interface{}) {
+	b.mu.Lock()
+	e := b.appendReplay(v)
+	for _, c := range b.cs {
+		// send but do not block for it
+		select {
+		case c <- v:
+		default:
+			log.Printf("failed to broadcast %v to %v as the receiving channel is busy\n", v, c)
+			metrics.BroadcastDrops.Inc()
+		}
+	}
+	b.mu.Unlock()
+
+	b.persist(e)
+}
+
+// appendReplay appends v to the in-memory replay buffer. Callers must
+// hold b.mu.
+func (b *Broadcaster) appendReplay(v interface{}) entry {
+	e := entry{V: v, Time: time.Now()}
+
+	b.replay = append(b.replay, e)
+	if len(b.replay) > b.replaySize {
+		b.replay = b.replay[len(b.replay)-b.replaySize:]
+	}
+	return e
+}
+
+// persist appends e to the on-disk replay log, if configured.
+func (b *Broadcaster) persist(e entry) {
+	b.persistMu.Lock()
+	defer b.persistMu.Unlock()
+
+	if b.persistFile == nil {
+		return
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("broadcaster: failed to marshal replay entry: %v", err)
+		return
+	}
+	data = append(data, '\n')
+
+	if _, err := b.persistFile.Write(data); err != nil {
+		log.Printf("broadcaster: failed to persist replay entry: %v", err)
+		return
+	}
+	b.persistSize += int64(len(data))
+
+	if b.persistSize >= b.persistMaxBytes {
+		b.rotate()
+	}
+}
+
+// rotate moves the current replay log aside and starts a fresh one.
+// Callers must hold b.persistMu.
+func (b *Broadcaster) rotate() {
+	b.persistFile.Close()
+
+	rotated := fmt.Sprintf("%s.%d", b.persistPath, time.Now().UnixNano())
+	if err := os.Rename(b.persistPath, rotated); err != nil {
+		log.Printf("broadcaster: failed to rotate replay log: %v", err)
+	}
+
+	f, err := os.OpenFile(b.persistPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		log.Printf("broadcaster: failed to reopen replay log after rotation: %v", err)
+		b.persistFile = nil
+		return
+	}
+	b.persistFile = f
+	b.persistSize = 0
+}
+
+// Close closes every subscriber's channel.
+func (b *Broadcaster) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, c := range b.cs {
+		close(c)
+	}
+}
@@ -0,0 +1,117 @@
+package broadcaster
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReplayBufferBounded(t *testing.T) {
+	b := New(Config{ReplaySize: 3})
+
+	for i := 0; i < 5; i++ {
+		b.Pub(i)
+	}
+
+	c := make(chan interface{}, 1)
+	backlog := b.Sub(c, time.Time{})
+	if len(backlog) != 3 {
+		t.Fatalf("got %d replayed points, want 3", len(backlog))
+	}
+	if backlog[0] != 2 || backlog[2] != 4 {
+		t.Fatalf("got %v, want the 3 most recent points [2 3 4]", backlog)
+	}
+}
+
+func TestSubSinceFiltersReplay(t *testing.T) {
+	b := New(Config{ReplaySize: 10})
+
+	b.Pub("before")
+	cutoff := time.Now()
+	b.Pub("after")
+
+	c := make(chan interface{}, 1)
+	backlog := b.Sub(c, cutoff)
+	if len(backlog) != 1 || backlog[0] != "after" {
+		t.Fatalf("got %v, want only the point published after cutoff", backlog)
+	}
+}
+
+func TestPersistAndReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replay.jsonl")
+
+	b := New(Config{ReplaySize: 10, PersistPath: path})
+	b.Pub("one")
+	b.Pub("two")
+
+	// persist runs synchronously within Pub (just outside b.mu), so the
+	// file is up to date as soon as Pub returns.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading persisted replay log: %v", err)
+	}
+
+	var lines int
+	for _, line := range splitLines(data) {
+		if line == "" {
+			continue
+		}
+		var e struct {
+			V interface{} `json:"v"`
+		}
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("unmarshaling persisted entry: %v", err)
+		}
+		lines++
+	}
+	if lines != 2 {
+		t.Fatalf("got %d persisted entries, want 2", lines)
+	}
+
+	reloaded := New(Config{ReplaySize: 10, PersistPath: path})
+	c := make(chan interface{}, 1)
+	backlog := reloaded.Sub(c, time.Time{})
+	if len(backlog) != 2 {
+		t.Fatalf("got %d points reloaded from disk, want 2", len(backlog))
+	}
+}
+
+func TestRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replay.jsonl")
+
+	b := New(Config{ReplaySize: 10, PersistPath: path, PersistMaxBytes: 1})
+	b.Pub("one")
+	b.Pub("two")
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("reading persist dir: %v", err)
+	}
+
+	var rotated bool
+	for _, e := range entries {
+		if e.Name() != "replay.jsonl" {
+			rotated = true
+		}
+	}
+	if !rotated {
+		t.Fatal("expected a rotated replay log alongside the active one")
+	}
+}
+
+func splitLines(data []byte) []string {
+	var lines []string
+	start := 0
+	for i, b := range data {
+		if b == '\n' {
+			lines = append(lines, string(data[start:i]))
+			start = i + 1
+		}
+	}
+	if start < len(data) {
+		lines = append(lines, string(data[start:]))
+	}
+	return lines
+}
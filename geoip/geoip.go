@@ -0,0 +1,286 @@
+// Package geoip manages a GeoLite2-City database: downloading it on demand,
+// caching it on disk, and refreshing it in the background so a long-running
+// process always has a reasonably current copy without requiring a restart.
+package geoip
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	maxminddb "github.com/oschwald/maxminddb-golang"
+)
+
+const (
+	downloadURL = "https://download.maxmind.com/app/geoip_download?edition_id=GeoLite2-City&license_key=%s&suffix=tar.gz"
+
+	defaultCacheDir        = "geoip-cache"
+	defaultCacheFile       = "GeoLite2-City.mmdb"
+	defaultRefreshInterval = 7 * 24 * time.Hour
+	fetchTimeout           = 2 * time.Minute
+)
+
+// Config controls where the database comes from and how often it is
+// refreshed.
+type Config struct {
+	// LicenseKey is the MaxMind license key used to build the download URL.
+	// Read from MAXMIND_LICENSE_KEY if not set explicitly.
+	LicenseKey string
+
+	// URL overrides the MaxMind download URL entirely. It may use the
+	// file:// scheme to point at a local tarball, which is mainly useful
+	// for tests and air-gapped deployments.
+	URL string
+
+	// CacheDir is where the extracted .mmdb is stored between runs.
+	// Defaults to defaultCacheDir in the working directory.
+	CacheDir string
+
+	// RefreshInterval is how often the database is re-downloaded in the
+	// background. Defaults to one week.
+	RefreshInterval time.Duration
+}
+
+func (c Config) cacheDir() string {
+	if c.CacheDir != "" {
+		return c.CacheDir
+	}
+	return defaultCacheDir
+}
+
+func (c Config) cachePath() string {
+	return filepath.Join(c.cacheDir(), defaultCacheFile)
+}
+
+func (c Config) refreshInterval() time.Duration {
+	if c.RefreshInterval > 0 {
+		return c.RefreshInterval
+	}
+	return defaultRefreshInterval
+}
+
+func (c Config) url() (string, error) {
+	if c.URL != "" {
+		return c.URL, nil
+	}
+	if c.LicenseKey == "" {
+		return "", errors.New("geoip: no URL and no license key configured")
+	}
+	return fmt.Sprintf(downloadURL, c.LicenseKey), nil
+}
+
+// DB serves MaxMind lookups against the current GeoLite2-City database,
+// swapping to a freshly downloaded copy whenever one becomes available.
+type DB struct {
+	cfg    Config
+	reader atomic.Pointer[maxminddb.Reader]
+	client *http.Client
+}
+
+// Open downloads the database and loads it, on every start, whether or
+// not a cached copy exists. If that download fails, a stale cached copy on disk is used
+// instead so that a flaky network at startup doesn't prevent the server
+// from running at all. It returns an error only if neither a fresh
+// download nor a cached copy is available.
+func Open(cfg Config) (*DB, error) {
+	db := &DB{
+		cfg:    cfg,
+		client: &http.Client{Timeout: fetchTimeout},
+	}
+
+	if err := db.refresh(); err != nil {
+		log.Printf("geoip: initial refresh failed, falling back to cache: %v", err)
+		r, openErr := maxminddb.Open(cfg.cachePath())
+		if openErr != nil {
+			return nil, fmt.Errorf("geoip: no database available: refresh failed (%v) and no cached copy found (%v)", err, openErr)
+		}
+		db.reader.Store(r)
+	}
+
+	go db.refreshLoop()
+
+	return db, nil
+}
+
+// Lookup resolves ip against the current database, matching the signature
+// callers already use against a plain *maxminddb.Reader.
+func (db *DB) Lookup(ip net.IP, result interface{}) error {
+	r := db.reader.Load()
+	if r == nil {
+		return errors.New("geoip: database not loaded")
+	}
+	return r.Lookup(ip, result)
+}
+
+// Close releases the currently loaded database.
+func (db *DB) Close() error {
+	if r := db.reader.Load(); r != nil {
+		return r.Close()
+	}
+	return nil
+}
+
+func (db *DB) refreshLoop() {
+	t := time.NewTicker(db.cfg.refreshInterval())
+	defer t.Stop()
+
+	for range t.C {
+		if err := db.refresh(); err != nil {
+			log.Printf("geoip: background refresh failed, keeping existing database: %v", err)
+		}
+	}
+}
+
+// refresh downloads the current database, verifies it against MaxMind's
+// sha256 sidecar when that sidecar can be fetched (verification is
+// skipped, with a log line, when it cannot), and atomically swaps it in. The previous reader, if any,
+// is closed once in-flight lookups have had a chance to finish.
+func (db *DB) refresh() error {
+	url, err := db.cfg.url()
+	if err != nil {
+		return err
+	}
+
+	tarball, err := fetch(db.client, url)
+	if err != nil {
+		return fmt.Errorf("fetching %s: %w", url, err)
+	}
+
+	wantSum, err := fetch(db.client, url+".sha256")
+	if err == nil {
+		if err := verifySHA256(tarball, wantSum); err != nil {
+			return err
+		}
+	} else {
+		log.Printf("geoip: no sha256 sidecar at %s.sha256, skipping checksum verification: %v", url, err)
+	}
+
+	mmdb, err := extractMMDB(tarball)
+	if err != nil {
+		return fmt.Errorf("extracting mmdb: %w", err)
+	}
+
+	path, err := writeCached(db.cfg.cacheDir(), db.cfg.cachePath(), mmdb)
+	if err != nil {
+		return fmt.Errorf("caching mmdb: %w", err)
+	}
+
+	r, err := maxminddb.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening refreshed mmdb: %w", err)
+	}
+
+	old := db.reader.Swap(r)
+	if old != nil {
+		// Give in-flight lookups a moment to finish against the old
+		// reader before unmapping it.
+		time.AfterFunc(10*time.Second, func() {
+			if err := old.Close(); err != nil {
+				log.Printf("geoip: closing superseded database: %v", err)
+			}
+		})
+	}
+
+	return nil
+}
+
+func fetch(client *http.Client, rawURL string) ([]byte, error) {
+	if path, ok := strings.CutPrefix(rawURL, "file://"); ok {
+		return os.ReadFile(path)
+	}
+
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+func verifySHA256(data, sidecar []byte) error {
+	// MaxMind's sidecar is "<hex digest>  <filename>\n".
+	fields := strings.Fields(string(sidecar))
+	if len(fields) == 0 {
+		return errors.New("empty sha256 sidecar")
+	}
+	want := strings.ToLower(fields[0])
+
+	sum := sha256.Sum256(data)
+	got := hex.EncodeToString(sum[:])
+
+	if got != want {
+		return fmt.Errorf("sha256 mismatch: got %s, want %s", got, want)
+	}
+	return nil
+}
+
+func extractMMDB(tarball []byte) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(tarball))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasSuffix(hdr.Name, ".mmdb") {
+			return io.ReadAll(tr)
+		}
+	}
+
+	return nil, errors.New("no .mmdb file found in tarball")
+}
+
+func writeCached(dir, path string, data []byte) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".mmdb-*")
+	if err != nil {
+		return "", err
+	}
+	tmpName := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return "", err
+	}
+
+	if err := os.Rename(tmpName, path); err != nil {
+		os.Remove(tmpName)
+		return "", err
+	}
+
+	return path, nil
+}
@@ -0,0 +1,78 @@
+package geoip
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"testing"
+)
+
+func TestVerifySHA256(t *testing.T) {
+	data := []byte("hello world")
+	// sha256("hello world")
+	sidecar := []byte("b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9  GeoLite2-City.tar.gz\n")
+
+	if err := verifySHA256(data, sidecar); err != nil {
+		t.Fatalf("expected matching sidecar to verify, got: %v", err)
+	}
+
+	if err := verifySHA256([]byte("tampered"), sidecar); err == nil {
+		t.Fatal("expected mismatched data to fail verification")
+	}
+
+	if err := verifySHA256(data, nil); err == nil {
+		t.Fatal("expected empty sidecar to fail verification")
+	}
+}
+
+func TestExtractMMDB(t *testing.T) {
+	tarball := buildTarGz(t, map[string][]byte{
+		"GeoLite2-City_20240101/README.txt":         []byte("not the database"),
+		"GeoLite2-City_20240101/GeoLite2-City.mmdb": []byte("mmdb contents"),
+	})
+
+	mmdb, err := extractMMDB(tarball)
+	if err != nil {
+		t.Fatalf("extractMMDB returned error: %v", err)
+	}
+	if string(mmdb) != "mmdb contents" {
+		t.Fatalf("got %q, want %q", mmdb, "mmdb contents")
+	}
+}
+
+func TestExtractMMDBMissing(t *testing.T) {
+	tarball := buildTarGz(t, map[string][]byte{
+		"GeoLite2-City_20240101/README.txt": []byte("not the database"),
+	})
+
+	if _, err := extractMMDB(tarball); err == nil {
+		t.Fatal("expected an error when no .mmdb file is present")
+	}
+}
+
+func buildTarGz(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	for name, contents := range files {
+		hdr := &tar.Header{Name: name, Size: int64(len(contents)), Mode: 0o644}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("writing tar header: %v", err)
+		}
+		if _, err := tw.Write(contents); err != nil {
+			t.Fatalf("writing tar contents: %v", err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatalf("closing tar writer: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("closing gzip writer: %v", err)
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,159 @@
+package logsource
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultDockerSocket = "/var/run/docker.sock"
+
+	// maxDockerFrameSize caps the size a multiplexed-stream frame header
+	// may claim. Docker log lines don't legitimately approach this; the
+	// cap exists so a desynced stream can't make demux allocate an
+	// attacker- or corruption-sized buffer.
+	maxDockerFrameSize = 1 << 20
+)
+
+// dockerSource attaches to the log stream of one or more containers via
+// the Docker Engine API over its Unix socket, rather than pulling in the
+// full Docker SDK for what's ultimately a single GET request per
+// container.
+type dockerSource struct {
+	lines chan string
+	conns []net.Conn
+	wg    sync.WaitGroup
+}
+
+func newDockerSource(containers []string, socketPath string) (*dockerSource, error) {
+	if socketPath == "" {
+		socketPath = defaultDockerSocket
+	}
+
+	d := &dockerSource{lines: make(chan string)}
+
+	for _, name := range containers {
+		conn, err := net.Dial("unix", socketPath)
+		if err != nil {
+			d.Stop()
+			return nil, fmt.Errorf("dialing docker socket: %w", err)
+		}
+
+		url := fmt.Sprintf("http://unix/containers/%s/logs?follow=1&stdout=1&stderr=1&tail=0", name)
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			conn.Close()
+			d.Stop()
+			return nil, err
+		}
+		if err := req.Write(conn); err != nil {
+			conn.Close()
+			d.Stop()
+			return nil, fmt.Errorf("requesting logs for container %s: %w", name, err)
+		}
+
+		d.conns = append(d.conns, conn)
+		d.wg.Add(1)
+		go d.demux(name, conn)
+	}
+
+	go func() {
+		d.wg.Wait()
+		close(d.lines)
+	}()
+
+	return d, nil
+}
+
+// demux reads the container's log stream, dispatching to the framing
+// the Docker Engine API actually used: containers started with a TTY get
+// a raw (unframed) stream, everything else is multiplexed with an 8-byte
+// header per frame ([stream type, 0, 0, 0, big-endian uint32 size])
+// followed by size bytes of payload.
+func (d *dockerSource) demux(name string, conn net.Conn) {
+	defer d.wg.Done()
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		log.Printf("logsource: docker logs response for %s: %v", name, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("logsource: docker logs for %s: unexpected status %s", name, resp.Status)
+		return
+	}
+
+	if resp.Header.Get("Content-Type") == "application/vnd.docker.multiplexed-stream" {
+		d.demuxMultiplexed(name, resp.Body)
+	} else {
+		d.demuxRaw(name, resp.Body)
+	}
+}
+
+// demuxMultiplexed reads frame-headered stdout/stderr as Docker emits it
+// for containers started without a TTY.
+func (d *dockerSource) demuxMultiplexed(name string, r io.Reader) {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err != io.EOF {
+				log.Printf("logsource: docker logs stream for %s: %v", name, err)
+			}
+			return
+		}
+
+		size := binary.BigEndian.Uint32(header[4:8])
+		if size > maxDockerFrameSize {
+			log.Printf("logsource: docker logs frame for %s claims %d bytes, exceeding the %d byte cap; dropping connection", name, size, maxDockerFrameSize)
+			return
+		}
+
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return
+		}
+
+		for _, line := range strings.Split(strings.TrimRight(string(payload), "\n"), "\n") {
+			if line != "" {
+				d.lines <- line
+			}
+		}
+	}
+}
+
+// demuxRaw reads an unframed TTY stream, where stdout and stderr are
+// already interleaved with no per-frame header to strip.
+func (d *dockerSource) demuxRaw(name string, r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxDockerFrameSize)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			d.lines <- line
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("logsource: docker logs stream for %s: %v", name, err)
+	}
+}
+
+func (d *dockerSource) Lines() <-chan string { return d.lines }
+
+func (d *dockerSource) Stop() error {
+	var firstErr error
+	for _, c := range d.conns {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,39 @@
+package logsource
+
+import (
+	"os"
+
+	"github.com/hpcloud/tail"
+)
+
+// fileSource tails a local file, the original (and still default) way
+// this tool gets its log lines.
+type fileSource struct {
+	t     *tail.Tail
+	lines chan string
+}
+
+func newFileSource(path string) (*fileSource, error) {
+	t, err := tail.TailFile(path, tail.Config{
+		Follow:   true,
+		Location: &tail.SeekInfo{Whence: os.SEEK_END},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	f := &fileSource{t: t, lines: make(chan string)}
+	go f.run()
+	return f, nil
+}
+
+func (f *fileSource) run() {
+	defer close(f.lines)
+	for line := range f.t.Lines {
+		f.lines <- line.Text
+	}
+}
+
+func (f *fileSource) Lines() <-chan string { return f.lines }
+
+func (f *fileSource) Stop() error { return f.t.Stop() }
@@ -0,0 +1,63 @@
+package logsource
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+)
+
+// journaldSource reads `journalctl -f -o json` rather than linking against
+// libsystemd, so it works anywhere journalctl is on PATH.
+type journaldSource struct {
+	cmd   *exec.Cmd
+	lines chan string
+}
+
+func newJournaldSource(unit string) (*journaldSource, error) {
+	args := []string{"-f", "-o", "json"}
+	if unit != "" {
+		args = append(args, "-u", unit)
+	}
+
+	cmd := exec.Command("journalctl", args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting journalctl: %w", err)
+	}
+
+	j := &journaldSource{cmd: cmd, lines: make(chan string)}
+	go j.run(stdout)
+	return j, nil
+}
+
+func (j *journaldSource) run(r io.Reader) {
+	defer close(j.lines)
+
+	dec := json.NewDecoder(r)
+	for {
+		var entry struct {
+			Message string `json:"MESSAGE"`
+		}
+		if err := dec.Decode(&entry); err != nil {
+			if err != io.EOF {
+				log.Printf("logsource: journald decode error: %v", err)
+			}
+			return
+		}
+		j.lines <- entry.Message
+	}
+}
+
+func (j *journaldSource) Lines() <-chan string { return j.lines }
+
+func (j *journaldSource) Stop() error {
+	if j.cmd.Process == nil {
+		return nil
+	}
+	return j.cmd.Process.Kill()
+}
@@ -0,0 +1,94 @@
+// Package logsource abstracts over the different places IP-bearing log
+// lines can come from, so the tailing goroutine in main doesn't need to
+// know whether it's reading a local file, journald, syslog, or a Docker
+// container's logs.
+package logsource
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// Source produces a stream of raw log lines until Stop is called, at
+// which point its Lines channel is closed.
+type Source interface {
+	Lines() <-chan string
+	Stop() error
+}
+
+// Config collects the settings needed by any of the supported backends.
+// Only the fields relevant to the selected backend are used.
+type Config struct {
+	// FilePath is the file tailed by the "file" source.
+	FilePath string
+
+	// JournaldUnit restricts the "journald" source to a single systemd
+	// unit. If empty, all units are read.
+	JournaldUnit string
+
+	// SyslogNetwork and SyslogAddr configure the "syslog" source, e.g.
+	// "udp" and ":514".
+	SyslogNetwork string
+	SyslogAddr    string
+
+	// DockerContainers is the list of container names or IDs the
+	// "docker" source attaches to.
+	DockerContainers []string
+	// DockerSocket is the path to the Docker Engine API socket.
+	// Defaults to /var/run/docker.sock.
+	DockerSocket string
+}
+
+// DefaultExtractor matches the first IPv4-shaped substring in a line.
+var DefaultExtractor = regexp.MustCompile(`\d+\.\d+\.\d+\.\d+`)
+
+// New builds the Source selected by kind, which corresponds to the
+// LOG_SOURCE env var: "file" (the default), "journald", "syslog", or
+// "docker".
+func New(kind string, cfg Config) (Source, error) {
+	switch kind {
+	case "", "file":
+		if cfg.FilePath == "" {
+			return nil, errors.New("logsource: LOG_FILENAME is required for the file source")
+		}
+		return newFileSource(cfg.FilePath)
+	case "journald":
+		return newJournaldSource(cfg.JournaldUnit)
+	case "syslog":
+		return newSyslogSource(cfg.SyslogNetwork, cfg.SyslogAddr)
+	case "docker":
+		if len(cfg.DockerContainers) == 0 {
+			return nil, errors.New("logsource: DOCKER_CONTAINERS is required for the docker source")
+		}
+		return newDockerSource(cfg.DockerContainers, cfg.DockerSocket)
+	default:
+		return nil, fmt.Errorf("logsource: unknown LOG_SOURCE %q", kind)
+	}
+}
+
+// ExtractIP pulls an IP address out of line using re, which defaults to
+// DefaultExtractor when nil.
+func ExtractIP(re *regexp.Regexp, line string) (string, bool) {
+	if re == nil {
+		re = DefaultExtractor
+	}
+
+	idx := re.FindStringSubmatchIndex(line)
+	if idx == nil {
+		return "", false
+	}
+
+	for _, name := range re.SubexpNames() {
+		if name != "ip" {
+			continue
+		}
+		ip := string(re.ExpandString(nil, "$ip", line, idx))
+		if ip == "" {
+			return "", false
+		}
+		return ip, true
+	}
+
+	return re.FindString(line), true
+}
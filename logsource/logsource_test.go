@@ -0,0 +1,34 @@
+package logsource
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestExtractIPDefault(t *testing.T) {
+	ip, ok := ExtractIP(nil, "client connected from 203.0.113.9 on port 443")
+	if !ok {
+		t.Fatal("expected to find an IP")
+	}
+	if ip != "203.0.113.9" {
+		t.Fatalf("got %q, want %q", ip, "203.0.113.9")
+	}
+}
+
+func TestExtractIPNoMatch(t *testing.T) {
+	if _, ok := ExtractIP(nil, "no address here"); ok {
+		t.Fatal("expected no match")
+	}
+}
+
+func TestExtractIPNamedGroup(t *testing.T) {
+	re := regexp.MustCompile(`remote_addr=(?P<ip>\S+)`)
+
+	ip, ok := ExtractIP(re, `method=GET remote_addr=198.51.100.2 status=200`)
+	if !ok {
+		t.Fatal("expected to find an IP")
+	}
+	if ip != "198.51.100.2" {
+		t.Fatalf("got %q, want %q", ip, "198.51.100.2")
+	}
+}
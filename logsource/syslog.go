@@ -0,0 +1,162 @@
+package logsource
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const defaultSyslogNetwork = "udp"
+
+// syslogHeaderRE strips an RFC5424 header (PRI VERSION TIMESTAMP HOSTNAME
+// APP-NAME PROCID MSGID STRUCTURED-DATA) off the front of a line, leaving
+// just the MSG part.
+var syslogHeaderRE = regexp.MustCompile(`^<\d+>\d+\s+\S+\s+\S+\s+\S+\s+\S+\s+\S+\s+(?:\[.*\]|-)\s*`)
+
+// syslogSource listens for RFC5424-framed messages on a UDP or TCP socket.
+// TCP connections are read per RFC6587: octet-counting framing when a
+// frame opens with a decimal length, non-transparent (LF-terminated)
+// framing otherwise.
+type syslogSource struct {
+	closer io.Closer
+	lines  chan string
+
+	mu    sync.Mutex
+	conns []net.Conn
+	wg    sync.WaitGroup
+}
+
+func newSyslogSource(network, addr string) (*syslogSource, error) {
+	if network == "" {
+		network = defaultSyslogNetwork
+	}
+
+	s := &syslogSource{lines: make(chan string)}
+
+	switch network {
+	case "udp":
+		conn, err := net.ListenPacket(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		s.closer = conn
+		go s.readPacket(conn)
+	default:
+		ln, err := net.Listen(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		s.closer = ln
+		go s.acceptLoop(ln)
+	}
+
+	return s, nil
+}
+
+func (s *syslogSource) readPacket(conn net.PacketConn) {
+	defer close(s.lines)
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		s.emit(string(buf[:n]))
+	}
+}
+
+func (s *syslogSource) acceptLoop(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			break
+		}
+
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.readStream(conn)
+	}
+
+	s.wg.Wait()
+	close(s.lines)
+}
+
+// readStream reads RFC6587-framed messages off conn: octet-counting
+// framing ("MSGLEN SP MSG") when a frame opens with a decimal length, or
+// non-transparent (LF-terminated) framing otherwise, since real TCP
+// syslog senders use either.
+func (s *syslogSource) readStream(conn net.Conn) {
+	defer s.wg.Done()
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	for {
+		msg, err := readSyslogFrame(r)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("logsource: syslog stream read error: %v", err)
+			}
+			return
+		}
+		s.emit(msg)
+	}
+}
+
+// readSyslogFrame reads a single message from r.
+func readSyslogFrame(r *bufio.Reader) (string, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return "", err
+	}
+
+	if b[0] < '0' || b[0] > '9' {
+		line, err := r.ReadString('\n')
+		if line == "" {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	lenStr, err := r.ReadString(' ')
+	if err != nil {
+		return "", err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(lenStr))
+	if err != nil {
+		return "", fmt.Errorf("logsource: invalid syslog octet count %q: %w", lenStr, err)
+	}
+
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return "", err
+	}
+	return string(msg), nil
+}
+
+func (s *syslogSource) emit(raw string) {
+	s.lines <- syslogHeaderRE.ReplaceAllString(raw, "")
+}
+
+func (s *syslogSource) Lines() <-chan string { return s.lines }
+
+func (s *syslogSource) Stop() error {
+	err := s.closer.Close()
+
+	s.mu.Lock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+
+	return err
+}
@@ -9,93 +9,91 @@ import (
 	"os"
 	"os/signal"
 	"regexp"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/gorilla/websocket"
-	"github.com/hpcloud/tail"
-	maxminddb "github.com/oschwald/maxminddb-golang"
-)
-
-type broadcaster struct {
-	mu sync.Mutex
-	cs []chan<- interface{}
-}
-
-func (b *broadcaster) sub(c chan<- interface{}) {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-
-	b.cs = append(b.cs, c)
-}
-
-func (b *broadcaster) usub(c chan<- interface{}) {
-	b.mu.Lock()
-	defer b.mu.Unlock()
 
-	n := b.cs[:0]
-	for _, x := range b.cs {
-		if x != c {
-			n = append(n, x)
-		}
-	}
-	b.cs = n
-}
-
-func (b *broadcaster) pub(v interface{}) {
-	b.mu.Lock()
-	defer b.mu.Unlock()
-
-	for _, c := range b.cs {
-		// send but do not block for it
-		select {
-		case c <- v:
-		default:
-			log.Printf("failed to broadcast %v to %v as the receiving channel is busy\n", v, c)
-		}
-	}
-}
-
-func (b *broadcaster) close() {
-	b.mu.Lock()
-	defer b.mu.Unlock()
+	"github.com/scramble-suit/geoip-live-map/aggregate"
+	"github.com/scramble-suit/geoip-live-map/broadcaster"
+	"github.com/scramble-suit/geoip-live-map/geoip"
+	"github.com/scramble-suit/geoip-live-map/logsource"
+	"github.com/scramble-suit/geoip-live-map/metrics"
+)
 
-	for _, c := range b.cs {
-		close(c)
-	}
-}
+const defaultReplaySize = 1000
 
 type mmrecord struct {
 	Location struct {
 		Latitude  float64 `maxminddb:"latitude"`
 		Longitude float64 `maxminddb:"longitude"`
 	} `maxminddb:"location"`
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
 }
 
-var b *broadcaster
-
-func main() {
-	logFilename := os.Getenv("LOG_FILENAME")
-	if logFilename == "" {
-		log.Fatal("LOG_FILENAME env variable is required")
+// lookup resolves ip against gdb, recording lookup latency and, on
+// success, the resolved country in Prometheus.
+func lookup(ip net.IP) (mmrecord, error) {
+	start := time.Now()
+	res := mmrecord{}
+	err := gdb.Lookup(ip, &res)
+	metrics.LookupDuration.Observe(time.Since(start).Seconds())
+	if err == nil {
+		metrics.CountryTotal.WithLabelValues(res.Country.ISOCode).Inc()
 	}
+	return res, err
+}
 
-	gdb, err := maxminddb.Open("GeoLite2-City.mmdb")
+var b *broadcaster.Broadcaster
+var gdb *geoip.DB
+var trustedProxies []*net.IPNet
+
+func main() {
+	var err error
+	gdb, err = geoip.Open(geoip.Config{
+		LicenseKey: os.Getenv("MAXMIND_LICENSE_KEY"),
+		URL:        os.Getenv("GEOIP_URL"),
+		CacheDir:   os.Getenv("GEOIP_CACHE_DIR"),
+	})
 	if err != nil {
 		log.Fatalf("failed to open maxmind db: %v", err)
 	}
 	defer gdb.Close()
 
-	t, err := tail.TailFile(logFilename, tail.Config{
-		Follow:   true,
-		Location: &tail.SeekInfo{Whence: os.SEEK_END},
+	trustedProxies, err = parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatalf("failed to parse TRUSTED_PROXIES: %v", err)
+	}
+
+	var extractor *regexp.Regexp
+	if pattern := os.Getenv("IP_REGEXP"); pattern != "" {
+		extractor, err = regexp.Compile(pattern)
+		if err != nil {
+			log.Fatalf("failed to compile IP_REGEXP: %v", err)
+		}
+	}
+
+	src, err := logsource.New(os.Getenv("LOG_SOURCE"), logsource.Config{
+		FilePath:         os.Getenv("LOG_FILENAME"),
+		JournaldUnit:     os.Getenv("JOURNALD_UNIT"),
+		SyslogNetwork:    os.Getenv("SYSLOG_NETWORK"),
+		SyslogAddr:       os.Getenv("SYSLOG_LISTEN_ON"),
+		DockerContainers: splitNonEmpty(os.Getenv("DOCKER_CONTAINERS"), ","),
+		DockerSocket:     os.Getenv("DOCKER_SOCKET"),
 	})
 	if err != nil {
-		log.Fatalf("failed to tail %s: %v", "ips.log", err)
+		log.Fatalf("failed to start log source: %v", err)
 	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/ws", ws)
+	mux.HandleFunc("/myip", myip)
+	mux.Handle("/metrics", metrics.Handler())
 	mux.HandleFunc("/", index)
 	httpListenOn := os.Getenv("HTTP_LISTEN_ON")
 	if httpListenOn == "" {
@@ -114,28 +112,69 @@ func main() {
 		log.Println("server goroutine has exited")
 	}()
 
-	b = &broadcaster{}
+	replaySize := defaultReplaySize
+	if v := os.Getenv("REPLAY_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("failed to parse REPLAY_SIZE: %v", err)
+		}
+		replaySize = n
+	}
+
+	b = broadcaster.New(broadcaster.Config{
+		ReplaySize:  replaySize,
+		PersistPath: os.Getenv("REPLAY_PERSIST_PATH"),
+	})
+
+	aggPrecision := uint(0)
+	if v := os.Getenv("GEOHASH_PRECISION"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			log.Fatalf("failed to parse GEOHASH_PRECISION: %v", err)
+		}
+		aggPrecision = uint(n)
+	}
+	aggWindow := time.Duration(0)
+	if v := os.Getenv("AGGREGATE_WINDOW"); v != "" {
+		aggWindow, err = time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("failed to parse AGGREGATE_WINDOW: %v", err)
+		}
+	}
+
+	agg := aggregate.New(aggregate.Config{
+		Precision: aggPrecision,
+		Window:    aggWindow,
+	}, func(p aggregate.Point) {
+		b.Pub(p)
+	})
 
 	go func() {
 		wg.Add(1)
 		defer wg.Done()
 
-		ipregexp := regexp.MustCompile(`\d+\.\d+\.\d+\.\d+`)
-
-		for line := range t.Lines {
-			ipstr := ipregexp.FindString(line.Text)
-			if ipstr == "" {
-				log.Printf("failed to find IP addres in: %s", line.Text)
+		for line := range src.Lines() {
+			ipstr, ok := logsource.ExtractIP(extractor, line)
+			if !ok {
+				log.Printf("failed to find IP addres in: %s", line)
 				continue
 			}
+			metrics.IPsSeen.Inc()
+
 			ip := net.ParseIP(ipstr)
+			if ip == nil {
+				log.Printf("failed to parse IP %s", ipstr)
+				metrics.IPsDropped.WithLabelValues("parse").Inc()
+				continue
+			}
 
-			res := mmrecord{}
-			if err := gdb.Lookup(ip, &res); err != nil {
+			res, err := lookup(ip)
+			if err != nil {
 				log.Printf("failed to lookup ip %s location: %v", ipstr, err)
+				metrics.IPsDropped.WithLabelValues("lookup").Inc()
 				continue
 			}
-			b.pub([]float64{res.Location.Latitude, res.Location.Longitude})
+			agg.Add(res.Location.Latitude, res.Location.Longitude)
 		}
 		log.Println("log tailing goroutine has exited")
 	}()
@@ -144,9 +183,10 @@ func main() {
 	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
 	<-sigs
 	log.Println("interrupted, shutting down the server")
-	if err := t.Stop(); err != nil {
+	if err := src.Stop(); err != nil {
 		log.Println(err)
 	}
+	agg.Stop()
 	if err := server.Shutdown(context.Background()); err != nil {
 		log.Println(err)
 	}
@@ -168,10 +208,25 @@ func index(w http.ResponseWriter, r *http.Request) {
 
 var upgrader = websocket.Upgrader{}
 
+// ws upgrades r to a websocket and streams published points to it. An
+// optional ?since=<RFC3339 time> replays buffered points published at or
+// after that time before switching to the live feed; since is matched
+// against the broadcaster's publish time, not any per-line log
+// timestamp (see broadcaster.entry).
 func ws(w http.ResponseWriter, r *http.Request) {
 	log.Println("new websocket connection from " + r.RemoteAddr)
 	defer log.Println("websocket connection with " + r.RemoteAddr + " is closed")
 
+	var since time.Time
+	if s := r.URL.Query().Get("since"); s != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, "invalid since: "+err.Error(), 400)
+			return
+		}
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
@@ -179,22 +234,169 @@ func ws(w http.ResponseWriter, r *http.Request) {
 	}
 	defer conn.Close()
 
+	// Give the client a brief window to announce a newer protocol before
+	// we start writing; anything that doesn't speak up gets the
+	// original [lat, lng] array format.
+	proto := 1
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	var hello struct {
+		Proto int `json:"proto"`
+	}
+	if err := conn.ReadJSON(&hello); err == nil && hello.Proto > proto {
+		proto = hello.Proto
+	}
+	conn.SetReadDeadline(time.Time{})
+
 	c := make(chan interface{})
-	b.sub(c)
+	backlog := b.Sub(c, since)
 
 	go func() {
 		for {
 			if _, _, err := conn.NextReader(); err != nil {
-				b.usub(c)
+				b.Usub(c)
 				close(c)
 				break
 			}
 		}
 	}()
 
+	for _, v := range backlog {
+		if err := writePoint(conn, v, proto); err != nil {
+			log.Println(err)
+		}
+	}
+
 	for v := range c {
-		if err := conn.WriteJSON(v); err != nil {
+		if err := writePoint(conn, v, proto); err != nil {
 			log.Println(err)
 		}
 	}
 }
+
+// writePoint writes v to conn in the format negotiated with the client:
+// proto 1 (the default, for old clients) is the original [lat, lng]
+// array, proto 2 is the full {lat, lng, count} aggregate.Point object.
+func writePoint(conn *websocket.Conn, v interface{}, proto int) error {
+	if proto >= 2 {
+		return conn.WriteJSON(v)
+	}
+	lat, lng, ok := pointLatLng(v)
+	if !ok {
+		return conn.WriteJSON(v)
+	}
+	return conn.WriteJSON([]float64{lat, lng})
+}
+
+// pointLatLng extracts lat/lng from v, which is either a live
+// aggregate.Point or, for points read back from the replay log on disk,
+// the map[string]interface{} json.Unmarshal produces for an interface{}
+// field.
+func pointLatLng(v interface{}) (lat, lng float64, ok bool) {
+	switch p := v.(type) {
+	case aggregate.Point:
+		return p.Lat, p.Lng, true
+	case map[string]interface{}:
+		lat, latOK := p["lat"].(float64)
+		lng, lngOK := p["lng"].(float64)
+		return lat, lng, latOK && lngOK
+	default:
+		return 0, 0, false
+	}
+}
+
+// myip geolocates the caller and publishes their own pin, so a visitor
+// opening the map sees themselves appear on it.
+func myip(w http.ResponseWriter, r *http.Request) {
+	ip := clientIP(r, trustedProxies)
+	if ip == nil {
+		http.Error(w, "could not determine client IP", 400)
+		return
+	}
+
+	res, err := lookup(ip)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	b.Pub(aggregate.Point{Lat: res.Location.Latitude, Lng: res.Location.Longitude, Count: 1})
+	w.Write([]byte(ip.String()))
+}
+
+// splitNonEmpty splits s on sep, discarding empty fields, so an unset env
+// var yields nil rather than a single blank entry.
+func splitNonEmpty(s, sep string) []string {
+	if s == "" {
+		return nil
+	}
+
+	var out []string
+	for _, part := range strings.Split(s, sep) {
+		if part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// parseTrustedProxies parses a comma-separated list of CIDRs, as read from
+// the TRUSTED_PROXIES env var. An empty string yields no trusted proxies,
+// in which case X-Forwarded-For and X-Real-IP are never honored.
+func parseTrustedProxies(s string) ([]*net.IPNet, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var nets []*net.IPNet
+	for _, cidr := range strings.Split(s, ",") {
+		cidr = strings.TrimSpace(cidr)
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// clientIP resolves the caller's public IP. If r.RemoteAddr is a trusted
+// proxy, the left-most non-private address in X-Forwarded-For is used,
+// falling back to X-Real-IP; untrusted callers can't spoof either header
+// since they're ignored entirely in that case.
+func clientIP(r *http.Request, trusted []*net.IPNet) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote := net.ParseIP(host)
+
+	if isTrustedProxy(remote, trusted) {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			for _, part := range strings.Split(xff, ",") {
+				ip := net.ParseIP(strings.TrimSpace(part))
+				if ip != nil && !ip.IsPrivate() && !ip.IsLoopback() {
+					return ip
+				}
+			}
+		}
+		if xri := r.Header.Get("X-Real-IP"); xri != "" {
+			if ip := net.ParseIP(strings.TrimSpace(xri)); ip != nil {
+				return ip
+			}
+		}
+	}
+
+	return remote
+}
+
+func isTrustedProxy(ip net.IP, trusted []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/scramble-suit/geoip-live-map/aggregate"
+)
+
+func mustParseTrustedProxies(t *testing.T, cidrs ...string) []*net.IPNet {
+	t.Helper()
+
+	nets, err := parseTrustedProxies(strings.Join(cidrs, ","))
+	if err != nil {
+		t.Fatalf("parseTrustedProxies: %v", err)
+	}
+	return nets
+}
+
+func TestClientIPUntrustedRemote(t *testing.T) {
+	trusted := mustParseTrustedProxies(t, "10.0.0.0/8")
+
+	r := httptest.NewRequest(http.MethodGet, "/myip", nil)
+	r.RemoteAddr = "203.0.113.9:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+
+	ip := clientIP(r, trusted)
+	if ip.String() != "203.0.113.9" {
+		t.Fatalf("untrusted remote's X-Forwarded-For should be ignored, got %v", ip)
+	}
+}
+
+func TestClientIPTrustedProxyXFF(t *testing.T) {
+	trusted := mustParseTrustedProxies(t, "10.0.0.0/8")
+
+	r := httptest.NewRequest(http.MethodGet, "/myip", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "10.1.2.3, 198.51.100.1")
+
+	ip := clientIP(r, trusted)
+	if ip.String() != "198.51.100.1" {
+		t.Fatalf("expected the left-most non-private X-Forwarded-For entry, got %v", ip)
+	}
+}
+
+func TestClientIPTrustedProxyFallsBackToXRealIP(t *testing.T) {
+	trusted := mustParseTrustedProxies(t, "10.0.0.0/8")
+
+	r := httptest.NewRequest(http.MethodGet, "/myip", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Real-IP", "198.51.100.1")
+
+	ip := clientIP(r, trusted)
+	if ip.String() != "198.51.100.1" {
+		t.Fatalf("expected X-Real-IP fallback, got %v", ip)
+	}
+}
+
+func TestPointLatLngFromLivePoint(t *testing.T) {
+	lat, lng, ok := pointLatLng(aggregate.Point{Lat: 1.5, Lng: -2.5, Count: 3})
+	if !ok || lat != 1.5 || lng != -2.5 {
+		t.Fatalf("got (%v, %v, %v), want (1.5, -2.5, true)", lat, lng, ok)
+	}
+}
+
+func TestPointLatLngFromReplayedJSON(t *testing.T) {
+	v := map[string]interface{}{"lat": 1.5, "lng": -2.5, "count": 3.0}
+
+	lat, lng, ok := pointLatLng(v)
+	if !ok || lat != 1.5 || lng != -2.5 {
+		t.Fatalf("got (%v, %v, %v), want (1.5, -2.5, true)", lat, lng, ok)
+	}
+}
+
+func TestPointLatLngUnknownShape(t *testing.T) {
+	if _, _, ok := pointLatLng("not a point"); ok {
+		t.Fatal("expected an unrecognized shape to report ok=false")
+	}
+}
@@ -0,0 +1,71 @@
+// Package metrics holds the Prometheus collectors exposed on /metrics, so
+// this can actually be operated and alerted on rather than just watched by
+// eye.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// IPsSeen counts every IP address extracted from a log line.
+	IPsSeen = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "geoip_live_map_ips_seen_total",
+		Help: "Total number of IP addresses extracted from log lines.",
+	})
+
+	// IPsDropped counts IPs that failed to produce a point on the map,
+	// broken down by why.
+	IPsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "geoip_live_map_ips_dropped_total",
+		Help: "Total number of IPs dropped, by reason.",
+	}, []string{"reason"})
+
+	// CountryTotal counts successful lookups by the resolved ISO country
+	// code.
+	CountryTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "geoip_live_map_country_total",
+		Help: "Total number of resolved points, by ISO country code.",
+	}, []string{"country"})
+
+	// WSSubscribers is the number of websocket clients currently
+	// subscribed to the broadcaster.
+	WSSubscribers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "geoip_live_map_ws_subscribers",
+		Help: "Current number of subscribed websocket clients.",
+	})
+
+	// BroadcastDrops counts points dropped because a subscriber's
+	// channel was busy.
+	BroadcastDrops = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "geoip_live_map_broadcast_drops_total",
+		Help: "Total number of points dropped because a subscriber was busy.",
+	})
+
+	// LookupDuration tracks how long MaxMind lookups take.
+	LookupDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "geoip_live_map_lookup_duration_seconds",
+		Help:    "Time spent looking up an IP in the MaxMind database.",
+		Buckets: prometheus.DefBuckets,
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		IPsSeen,
+		IPsDropped,
+		CountryTotal,
+		WSSubscribers,
+		BroadcastDrops,
+		LookupDuration,
+	)
+}
+
+// Handler serves the collected metrics in the Prometheus exposition
+// format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}